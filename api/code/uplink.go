@@ -5,15 +5,20 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"os/exec"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"text/template"
+	"time"
 )
 
 /* WPA Supplicant Support */
@@ -23,12 +28,75 @@ var WpaConfigPath = TEST_PREFIX + "/configs/wifi_uplink/wpa.json"
 var WPAmtx sync.Mutex
 
 type WPANetwork struct {
-	Disabled bool
-	Password string
-	SSID     string
-	KeyMgmt  string
-	Priority string `json:",omitempty"`
-	BSSID    string `json:",omitempty"`
+	Disabled          bool
+	Password          string
+	SSID              string
+	KeyMgmt           string
+	Priority          string `json:",omitempty"`
+	BSSID             string `json:",omitempty"`
+	Hidden            bool   `json:",omitempty"`
+	TransitionDisable bool   `json:",omitempty"`
+	Pairwise          string `json:",omitempty"`
+	Group             string `json:",omitempty"`
+	Proto             string `json:",omitempty"`
+
+	//802.1X/EAP enterprise fields, only used when KeyMgmt contains WPA-EAP
+	EAP                string `json:",omitempty"`
+	Identity           string `json:",omitempty"`
+	AnonymousIdentity  string `json:",omitempty"`
+	Phase2             string `json:",omitempty"`
+	CACert             string `json:",omitempty"`
+	ClientCert         string `json:",omitempty"`
+	PrivateKey         string `json:",omitempty"`
+	PrivateKeyPassword string `json:",omitempty"`
+}
+
+// wifiCertsDir is where uploaded EAP certificate/key blobs are stored so
+// their paths can be validated and safely referenced from generated
+// wpa_supplicant.conf files.
+var wifiCertsDir = TEST_PREFIX + "/configs/wifi_uplink/certs/"
+
+func (n *WPANetwork) IsEAP() bool {
+	for _, part := range strings.Split(n.KeyMgmt, " ") {
+		if part == "WPA-EAP" {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSAEOnly reports whether this is a WPA3-Personal-only network, which
+// uses sae_password instead of psk.
+func (n *WPANetwork) IsSAEOnly() bool {
+	return strings.TrimSpace(n.KeyMgmt) == "SAE"
+}
+
+// IsOpen reports whether this is an unencrypted (no PSK/SAE/EAP) network.
+func (n *WPANetwork) IsOpen() bool {
+	return strings.TrimSpace(n.KeyMgmt) == "NONE"
+}
+
+func validateCertPath(path string) error {
+	if !strings.HasPrefix(path, wifiCertsDir) {
+		return fmt.Errorf("certificate path must be under " + wifiCertsDir)
+	}
+	if strings.Contains(path, "..") {
+		return fmt.Errorf("certificate path must not contain ..")
+	}
+	if strings.ContainsAny(path, "\n\"") {
+		return fmt.Errorf("certificate path must not contain newline or quote characters")
+	}
+	return nil
+}
+
+// validateQuotedField rejects characters that would let a value break out
+// of the double-quoted directives it gets interpolated into in the
+// generated wpa_supplicant.conf (e.g. identity="{{.Identity}}").
+func validateQuotedField(name, value string) error {
+	if strings.ContainsAny(value, "\n\"") {
+		return fmt.Errorf(name + " field must not contain newline or quote characters")
+	}
+	return nil
 }
 
 type WPAIface struct {
@@ -72,6 +140,10 @@ func (n *WPANetwork) Validate() error {
 	}
 
 	parts := strings.Split(n.KeyMgmt, " ")
+	if len(parts) == 1 && parts[0] == "NONE" {
+		return nil
+	}
+
 	for _, part := range parts {
 		if part == "WPA-PSK" {
 			continue
@@ -79,10 +151,64 @@ func (n *WPANetwork) Validate() error {
 			continue
 		} else if part == "SAE" {
 			continue
+		} else if part == "WPA-EAP" {
+			continue
 		}
 		return fmt.Errorf("KeyMgmt field has invalid field " + part)
 	}
 
+	for _, field := range []struct {
+		name  string
+		value string
+	}{
+		{"Identity", n.Identity},
+		{"AnonymousIdentity", n.AnonymousIdentity},
+		{"Phase2", n.Phase2},
+		{"PrivateKeyPassword", n.PrivateKeyPassword},
+	} {
+		if err := validateQuotedField(field.name, field.value); err != nil {
+			return err
+		}
+	}
+
+	if n.IsEAP() {
+		if n.EAP == "" {
+			return fmt.Errorf("EAP field must be set for WPA-EAP networks")
+		}
+
+		if n.Identity == "" {
+			return fmt.Errorf("Identity field must be set for WPA-EAP networks")
+		}
+
+		if n.CACert == "" {
+			return fmt.Errorf("CACert field must be set for WPA-EAP networks")
+		}
+
+		if err := validateCertPath(n.CACert); err != nil {
+			return err
+		}
+
+		// ClientCert/PrivateKey are optional for EAP types other than TLS
+		// (e.g. PEAP/TTLS can use a client cert for outer TLS), but whenever
+		// set they're still interpolated into a quoted conf directive, so
+		// validate them regardless of EAP type.
+		if n.ClientCert != "" {
+			if err := validateCertPath(n.ClientCert); err != nil {
+				return err
+			}
+		}
+
+		if n.PrivateKey != "" {
+			if err := validateCertPath(n.PrivateKey); err != nil {
+				return err
+			}
+		}
+
+		if n.EAP == "TLS" && (n.ClientCert == "" || n.PrivateKey == "") {
+			return fmt.Errorf("ClientCert and PrivateKey fields must be set for EAP-TLS")
+		}
+	}
+
 	return nil
 }
 
@@ -115,10 +241,30 @@ func writeWPAs(interfaces []InterfaceConfig, config WPASupplicantConfig) error {
       {{if not .Disabled}}
       network={
       	ssid="{{.SSID}}"
-      	psk="{{.Password}}"
+      	{{if .Hidden}}scan_ssid=1{{end}}
       	{{if .Priority}}priority={{.Priority}}{{end}}
       	{{if .BSSID}}bssid={{.BSSID}}{{end}}
         key_mgmt={{.KeyMgmt}}
+      	{{if .IsEAP}}
+      	eap={{.EAP}}
+      	identity="{{.Identity}}"
+      	{{if .AnonymousIdentity}}anonymous_identity="{{.AnonymousIdentity}}"{{end}}
+      	{{if .Phase2}}phase2="{{.Phase2}}"{{end}}
+      	ca_cert="{{.CACert}}"
+      	{{if .ClientCert}}client_cert="{{.ClientCert}}"{{end}}
+      	{{if .PrivateKey}}private_key="{{.PrivateKey}}"{{end}}
+      	{{if .PrivateKeyPassword}}private_key_passwd="{{.PrivateKeyPassword}}"{{end}}
+      	{{else if .IsSAEOnly}}
+      	sae_password="{{.Password}}"
+      	ieee80211w=2
+      	{{if .TransitionDisable}}transition_disable=1{{end}}
+      	{{else if .IsOpen}}
+      	{{else}}
+      	psk="{{.Password}}"
+      	{{end}}
+      	{{if .Pairwise}}pairwise={{.Pairwise}}{{end}}
+      	{{if .Group}}group={{.Group}}{{end}}
+      	{{if .Proto}}proto={{.Proto}}{{end}}
       }
       {{end}}
       {{end}}`)
@@ -292,6 +438,304 @@ func updateWpaSupplicantConfig(w http.ResponseWriter, r *http.Request) {
 
 }
 
+type WifiScanResult struct {
+	SSID      string
+	BSSID     string
+	Frequency int
+	Channel   int
+	Signal    int
+	KeyMgmt   string
+}
+
+func wifiFreqToChannel(freq int) int {
+	if freq >= 2412 && freq <= 2484 {
+		if freq == 2484 {
+			return 14
+		}
+		return (freq-2412)/5 + 1
+	}
+	if freq >= 5180 && freq <= 5900 {
+		return (freq - 5000) / 5
+	}
+	return 0
+}
+
+func wifiKeyMgmtFromFlags(flags string) string {
+	switch {
+	case strings.Contains(flags, "SAE"):
+		return "WPA3"
+	case strings.Contains(flags, "WPA2"):
+		return "WPA2"
+	case strings.Contains(flags, "WPA"):
+		return "WPA"
+	default:
+		return "OPEN"
+	}
+}
+
+func runWpaCli(iface string, args ...string) (string, error) {
+	cmdArgs := append([]string{"-i", iface}, args...)
+	out, err := exec.Command("wpa_cli", cmdArgs...).Output()
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func scanWifiIface(iface string) ([]WifiScanResult, error) {
+	_, err := runWpaCli(iface, "scan")
+	if err != nil {
+		return nil, fmt.Errorf("failed to trigger scan: %w", err)
+	}
+
+	// give wpa_supplicant time to complete the scan before reading results
+	time.Sleep(3 * time.Second)
+
+	out, err := runWpaCli(iface, "scan_results")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scan results: %w", err)
+	}
+
+	results := []WifiScanResult{}
+
+	lines := strings.Split(out, "\n")
+	for i, line := range lines {
+		if i == 0 {
+			//header line: bssid / frequency / signal level / flags / ssid
+			continue
+		}
+
+		fields := strings.SplitN(line, "\t", 5)
+		if len(fields) < 5 {
+			continue
+		}
+
+		freq, _ := strconv.Atoi(fields[1])
+		signal, _ := strconv.Atoi(fields[2])
+
+		results = append(results, WifiScanResult{
+			SSID:      fields[4],
+			BSSID:     fields[0],
+			Frequency: freq,
+			Channel:   wifiFreqToChannel(freq),
+			Signal:    signal,
+			KeyMgmt:   wifiKeyMgmtFromFlags(fields[3]),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Signal > results[j].Signal
+	})
+
+	return results, nil
+}
+
+func wifiScan(w http.ResponseWriter, r *http.Request) {
+	iface := r.URL.Query().Get("iface")
+
+	pattern := `^[a-zA-Z0-9]*(\.[a-zA-Z0-9]*)*$`
+	matched, err := regexp.MatchString(pattern, iface)
+	if iface == "" || err != nil || !matched {
+		http.Error(w, "Invalid iface name", 400)
+		return
+	}
+
+	results, err := scanWifiIface(iface)
+	if err != nil {
+		log.Println("Error scanning wifi iface:", err)
+		http.Error(w, "Failed to scan "+err.Error(), 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+type WifiConnectRequest struct {
+	Iface        string
+	SSID         string
+	Password     string
+	AutoPriority bool `json:",omitempty"`
+
+	//Security is the detected key management from a WifiScanResult
+	//("OPEN", "WPA", "WPA2", or "WPA3"); empty defaults to the mixed
+	//WPA2/WPA3-Personal KeyMgmt used before this field existed.
+	Security string `json:",omitempty"`
+}
+
+// keyMgmtForSecurity maps a WifiScanResult.KeyMgmt value to the
+// wpa_supplicant KeyMgmt string for a newly added network, so connecting
+// to an open network scanned off the air doesn't produce an unusable
+// PSK/SAE network block.
+func keyMgmtForSecurity(security string) string {
+	switch security {
+	case "OPEN":
+		return "NONE"
+	case "WPA3":
+		return "SAE"
+	case "WPA", "WPA2":
+		return "WPA-PSK WPA-PSK-SHA256"
+	default:
+		return "WPA-PSK WPA-PSK-SHA256 SAE"
+	}
+}
+
+func wifiConnectScanned(w http.ResponseWriter, r *http.Request) {
+	req := WifiConnectRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if req.Iface == "" || req.SSID == "" {
+		http.Error(w, "iface and SSID are required", 400)
+		return
+	}
+
+	WPAmtx.Lock()
+	config, err := loadWpaConfigLocked()
+	WPAmtx.Unlock()
+	if err != nil {
+		config = WPASupplicantConfig{}
+	}
+
+	var wpaIface *WPAIface
+	for i := range config.WPAs {
+		if config.WPAs[i].Iface == req.Iface {
+			wpaIface = &config.WPAs[i]
+			break
+		}
+	}
+
+	if wpaIface == nil {
+		config.WPAs = append(config.WPAs, WPAIface{Iface: req.Iface, Enabled: true})
+		wpaIface = &config.WPAs[len(config.WPAs)-1]
+	}
+
+	priority := ""
+	if req.AutoPriority {
+		priority = strconv.Itoa(highestPriority(wpaIface.Networks) + 1)
+	}
+
+	network := WPANetwork{
+		SSID:     req.SSID,
+		Password: req.Password,
+		KeyMgmt:  keyMgmtForSecurity(req.Security),
+		Priority: priority,
+	}
+
+	err = network.Validate()
+	if err != nil {
+		http.Error(w, "Failed to validate network "+err.Error(), 400)
+		return
+	}
+
+	found := false
+	for i := range wpaIface.Networks {
+		if wpaIface.Networks[i].SSID == req.SSID {
+			wpaIface.Networks[i] = network
+			found = true
+			break
+		}
+	}
+	if !found {
+		wpaIface.Networks = append(wpaIface.Networks, network)
+	}
+
+	wpaIface.Enabled = true
+
+	interfaces, err := updateInterfaceType(req.Iface, "Uplink", "wifi", true)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	err = insertWpaConfigAndSave(interfaces, *wpaIface)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	uplink_plugin := "WIFI-UPLINK"
+	started := enablePlugin(uplink_plugin)
+	if !started {
+		restartPlugin(uplink_plugin)
+	}
+}
+
+type WifiCertUploadRequest struct {
+	Iface    string
+	SSID     string
+	CertType string // "ca", "client", or "key"
+	Data     string // base64-encoded PEM contents
+}
+
+func uploadWifiCert(w http.ResponseWriter, r *http.Request) {
+	req := WifiCertUploadRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	pattern := `^[a-zA-Z0-9_.-]+$`
+	for name, value := range map[string]string{"Iface": req.Iface, "SSID": req.SSID} {
+		matched, err := regexp.MatchString(pattern, value)
+		if err != nil || !matched {
+			http.Error(w, name+" field is invalid", 400)
+			return
+		}
+	}
+
+	switch req.CertType {
+	case "ca", "client", "key":
+	default:
+		http.Error(w, "CertType field must be 'ca', 'client', or 'key'", 400)
+		return
+	}
+
+	data, err := base64.StdEncoding.DecodeString(req.Data)
+	if err != nil {
+		http.Error(w, "Data field must be base64 encoded", 400)
+		return
+	}
+
+	err = os.MkdirAll(wifiCertsDir, 0700)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Failed to create certs directory", 400)
+		return
+	}
+
+	fp := wifiCertsDir + req.Iface + "_" + req.SSID + "_" + req.CertType + ".pem"
+	err = ioutil.WriteFile(fp, data, 0600)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Failed to write certificate", 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"Path": fp})
+}
+
+func highestPriority(networks []WPANetwork) int {
+	highest := 0
+	for _, n := range networks {
+		if n.Priority == "" {
+			continue
+		}
+		p, err := strconv.Atoi(n.Priority)
+		if err == nil && p > highest {
+			highest = p
+		}
+	}
+	return highest
+}
+
 /* PPP Support */
 
 // /configs/ppp is mounted to /etc
@@ -299,13 +743,28 @@ var PPPConfigPath = TEST_PREFIX + "/configs/ppp/ppp.json"
 
 var PPPmtx sync.Mutex
 
+const (
+	PPPAuthCHAP     = "chap"
+	PPPAuthPAP      = "pap"
+	PPPAuthMSCHAPv2 = "mschapv2"
+)
+
 type PPPIface struct {
 	Iface    string
 	Enabled  bool
 	Username string
 	Secret   string
-	VLAN     string `json,optional`
-	MTU      string `json, optional`
+	VLAN     string `json:",omitempty"`
+	MTU      string `json:",omitempty"`
+
+	LCPEchoInterval string `json:",omitempty"`
+	LCPEchoFailure  string `json:",omitempty"`
+	IdleTimeout     string `json:",omitempty"`
+	DNSOverride     string `json:",omitempty"`
+	IPv6            bool   `json:",omitempty"`
+
+	//AuthMethod selects chap, pap, or mschapv2; empty defaults to chap
+	AuthMethod string `json:",omitempty"`
 }
 
 func (p *PPPIface) Validate() error {
@@ -339,9 +798,41 @@ func (p *PPPIface) Validate() error {
 		}
 	}
 
+	for _, field := range []struct {
+		name  string
+		value string
+	}{{"LCPEchoInterval", p.LCPEchoInterval}, {"LCPEchoFailure", p.LCPEchoFailure}, {"IdleTimeout", p.IdleTimeout}} {
+		if field.value == "" {
+			continue
+		}
+		v, err := strconv.Atoi(field.value)
+		if err != nil || v < 0 {
+			return fmt.Errorf(field.name + " field must contain numeric positive value")
+		}
+	}
+
+	switch p.AuthMethod {
+	case "", PPPAuthCHAP, PPPAuthPAP, PPPAuthMSCHAPv2:
+	default:
+		return fmt.Errorf("AuthMethod field must be 'chap', 'pap', or 'mschapv2'")
+	}
+
 	return nil
 }
 
+// RefuseFlags returns the pppd refuse-* options needed to force the
+// configured AuthMethod and reject the others.
+func (p *PPPIface) RefuseFlags() []string {
+	switch p.AuthMethod {
+	case PPPAuthPAP:
+		return []string{"refuse-chap", "refuse-mschap", "refuse-mschap-v2"}
+	case PPPAuthMSCHAPv2:
+		return []string{"refuse-pap", "refuse-chap", "refuse-mschap"}
+	default:
+		return []string{"refuse-pap", "refuse-mschap", "refuse-mschap-v2"}
+	}
+}
+
 type PPPConfig struct {
 	PPPs []PPPIface
 }
@@ -370,15 +861,12 @@ func loadPPPConfigLocked() (PPPConfig, error) {
 
 }
 
-func writePPP(interfaces []InterfaceConfig, config PPPConfig) error {
-	//assumes lock is held
-
-	//chap secrets hosts all credentials
-	tmpl, err := template.New("chap-secrets").Parse(`# Note this is an autogenerated file
-    # Secrets for authentication using CHAP
+func writeSecretsFile(fp string, authName string, ppps []PPPIface) error {
+	tmpl, err := template.New(authName + "-secrets").Parse(`# Note this is an autogenerated file
+    # Secrets for authentication using ` + strings.ToUpper(authName) + `
     # client        server  secret                  IP addresses
 
-    {{range .PPPs}}
+    {{range .}}
       "{{.Username}}" * "{{.Secret}}"
     {{end}}
     `)
@@ -389,13 +877,35 @@ func writePPP(interfaces []InterfaceConfig, config PPPConfig) error {
 	}
 
 	var result bytes.Buffer
-	err = tmpl.Execute(&result, config)
+	err = tmpl.Execute(&result, ppps)
 	if err != nil {
-		log.Println("Error executing chap-secrets template:", err)
+		log.Println("Error executing "+authName+"-secrets template:", err)
 		return err
 	}
-	fp := TEST_PREFIX + "/etc/ppp/chap-secrets"
-	err = ioutil.WriteFile(fp, result.Bytes(), 0600)
+
+	return ioutil.WriteFile(fp, result.Bytes(), 0600)
+}
+
+func writePPP(interfaces []InterfaceConfig, config PPPConfig) error {
+	//assumes lock is held
+
+	chapPPPs := []PPPIface{}
+	papPPPs := []PPPIface{}
+	for _, ppp := range config.PPPs {
+		if ppp.AuthMethod == PPPAuthPAP {
+			papPPPs = append(papPPPs, ppp)
+		} else {
+			//chap and mschapv2 share the chap-secrets format
+			chapPPPs = append(chapPPPs, ppp)
+		}
+	}
+
+	err := writeSecretsFile(TEST_PREFIX+"/etc/ppp/chap-secrets", "chap", chapPPPs)
+	if err != nil {
+		return err
+	}
+
+	err = writeSecretsFile(TEST_PREFIX+"/etc/ppp/pap-secrets", "pap", papPPPs)
 	if err != nil {
 		return err
 	}
@@ -410,10 +920,14 @@ func writePPP(interfaces []InterfaceConfig, config PPPConfig) error {
       persist
       {{if .MTU}}mtu {{.MTU}}{{end}}
       plugin rp-pppoe.so {{.Iface}}{{if .VLAN}}.{{.VLAN}}{{end}}
-      {{if .BSSID}}bssid={{.BSSID}}{{end}}
-      plugin rp-pppoe.so {{.Iface}}.{{.VLAN}}
       user "{{.Username}}"
-      `)
+      {{if .LCPEchoInterval}}lcp-echo-interval {{.LCPEchoInterval}}{{end}}
+      {{if .LCPEchoFailure}}lcp-echo-failure {{.LCPEchoFailure}}{{end}}
+      {{if .IdleTimeout}}idle {{.IdleTimeout}}{{end}}
+      {{if .DNSOverride}}ms-dns {{.DNSOverride}}{{end}}
+      {{if .IPv6}}+ipv6{{end}}
+      {{range .RefuseFlags}}{{.}}
+      {{end}}`)
 
 		if err != nil {
 			log.Println("Error parsing template:", err)
@@ -421,9 +935,9 @@ func writePPP(interfaces []InterfaceConfig, config PPPConfig) error {
 		}
 
 		var result bytes.Buffer
-		err = tmpl.Execute(&result, ppp)
+		err = tmpl.Execute(&result, &ppp)
 		if err != nil {
-			log.Println("Error executing chap-secrets template:", err)
+			log.Println("Error executing provider template:", err)
 			return err
 		}
 
@@ -540,6 +1054,173 @@ func updatePPPConfig(w http.ResponseWriter, r *http.Request) {
 
 }
 
+type PPPStatus struct {
+	Iface         string
+	Up            bool
+	UptimeSeconds int
+	LocalIP       string
+	RemoteIP      string
+	DNS           []string
+	MTU           int
+	RXBytes       uint64
+	TXBytes       uint64
+}
+
+type ipAddrInfo struct {
+	Local     string `json:"local"`
+	Family    string `json:"family"`
+	PrefixLen int    `json:"prefixlen"`
+}
+
+type ipAddrShowEntry struct {
+	IfName   string       `json:"ifname"`
+	MTU      int          `json:"mtu"`
+	AddrInfo []ipAddrInfo `json:"addr_info"`
+}
+
+func pppIfaceAddrs(iface string) (ipAddrShowEntry, error) {
+	out, err := exec.Command("ip", "-j", "addr", "show", iface).Output()
+	if err != nil {
+		return ipAddrShowEntry{}, err
+	}
+
+	entries := []ipAddrShowEntry{}
+	err = json.Unmarshal(out, &entries)
+	if err != nil || len(entries) == 0 {
+		return ipAddrShowEntry{}, fmt.Errorf("no addresses found for %s", iface)
+	}
+
+	return entries[0], nil
+}
+
+func pppIfaceByteCounters(iface string) (rx uint64, tx uint64) {
+	data, err := ioutil.ReadFile(TEST_PREFIX + "/proc/net/dev")
+	if err != nil {
+		return 0, 0
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != iface {
+			continue
+		}
+
+		fields := strings.Fields(parts[1])
+		if len(fields) < 9 {
+			continue
+		}
+
+		rx, _ = strconv.ParseUint(fields[0], 10, 64)
+		tx, _ = strconv.ParseUint(fields[8], 10, 64)
+		return rx, tx
+	}
+
+	return 0, 0
+}
+
+func getPPPStatus(w http.ResponseWriter, r *http.Request) {
+	iface := strings.TrimPrefix(r.URL.Path, "/ppp/status/")
+
+	pattern := `^[a-zA-Z0-9]*(\.[a-zA-Z0-9]*)*$`
+	matched, err := regexp.MatchString(pattern, iface)
+	if iface == "" || err != nil || !matched {
+		http.Error(w, "Invalid iface name", 400)
+		return
+	}
+
+	status := PPPStatus{Iface: iface}
+
+	pidPath := TEST_PREFIX + "/var/run/pppd-" + iface + ".pid"
+	info, err := os.Stat(pidPath)
+	if err == nil {
+		status.Up = true
+		status.UptimeSeconds = int(time.Since(info.ModTime()).Seconds())
+	}
+
+	addrs, err := pppIfaceAddrs(iface)
+	if err == nil {
+		status.MTU = addrs.MTU
+		for _, a := range addrs.AddrInfo {
+			if a.Family == "inet" {
+				status.LocalIP = a.Local
+			}
+		}
+	}
+
+	status.RXBytes, status.TXBytes = pppIfaceByteCounters(iface)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+type PPPoEDiscoverResult struct {
+	ACName      string
+	ServiceName string
+}
+
+type PPPoEDiscoverRequest struct {
+	Iface string
+}
+
+func pppoeDiscover(iface string) ([]PPPoEDiscoverResult, error) {
+	out, err := exec.Command("pppoe-discovery", "-I", iface).CombinedOutput()
+	if err != nil {
+		//pppoe-discovery exits non-zero when it finds no peer, but still
+		//reports what it saw on stdout/stderr
+		if len(out) == 0 {
+			return nil, err
+		}
+	}
+
+	results := []PPPoEDiscoverResult{}
+	var cur PPPoEDiscoverResult
+
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "Access-Concentrator:") {
+			if cur.ACName != "" {
+				results = append(results, cur)
+				cur = PPPoEDiscoverResult{}
+			}
+			cur.ACName = strings.TrimSpace(strings.TrimPrefix(line, "Access-Concentrator:"))
+		} else if strings.HasPrefix(line, "Service-Name:") {
+			cur.ServiceName = strings.TrimSpace(strings.TrimPrefix(line, "Service-Name:"))
+		}
+	}
+
+	if cur.ACName != "" {
+		results = append(results, cur)
+	}
+
+	return results, nil
+}
+
+func discoverPPPoE(w http.ResponseWriter, r *http.Request) {
+	req := PPPoEDiscoverRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	pattern := `^[a-zA-Z0-9]*(\.[a-zA-Z0-9]*)*$`
+	matched, err := regexp.MatchString(pattern, req.Iface)
+	if req.Iface == "" || err != nil || !matched {
+		http.Error(w, "Invalid iface name", 400)
+		return
+	}
+
+	results, err := pppoeDiscover(req.Iface)
+	if err != nil {
+		log.Println("Error running pppoe-discovery:", err)
+		http.Error(w, "Failed to discover PPPoE peers "+err.Error(), 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
 /* Setting IP */
 
 func updateIPConfig(w http.ResponseWriter, r *http.Request) {
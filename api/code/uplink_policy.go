@@ -0,0 +1,331 @@
+/*
+Multi-uplink failover / load-balancing policy
+
+When more than one Uplink interface (wifi, ppp, ethernet) is enabled at
+once, this ties them together under a single default route policy:
+either failover (ordered by metric, only the lowest-metric healthy
+uplink is used) or load-balancing (ECMP, weighted nexthops spread across
+all healthy uplinks). A background health-checker probes each uplink on
+an interval and the default route is reapplied after every round of
+probes, whether or not any uplink's up/down state changed.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var UplinkPolicyPath = TEST_PREFIX + "/configs/uplink/policy.json"
+
+var UplinkPolicyMtx sync.Mutex
+
+const (
+	UplinkModeFailover = "failover"
+	UplinkModeBalance  = "loadbalance"
+)
+
+type UplinkMember struct {
+	Name   string
+	Metric int `json:",omitempty"`
+	Weight int `json:",omitempty"`
+
+	//Metered uplinks (e.g. a cellular modem) are only brought into the
+	//route set once no non-metered member is healthy
+	Metered bool `json:",omitempty"`
+}
+
+type UplinkPolicy struct {
+	Mode                string
+	Members             []UplinkMember
+	HealthCheckTarget   string
+	HealthCheckInterval int //seconds
+	FailureThreshold    int //consecutive failures before marking an uplink down
+}
+
+func defaultUplinkPolicy() UplinkPolicy {
+	return UplinkPolicy{
+		Mode:                UplinkModeFailover,
+		HealthCheckTarget:   "1.1.1.1",
+		HealthCheckInterval: 10,
+		FailureThreshold:    3,
+	}
+}
+
+func (p *UplinkPolicy) Validate() error {
+	if p.Mode != UplinkModeFailover && p.Mode != UplinkModeBalance {
+		return fmt.Errorf("Mode field must be 'failover' or 'loadbalance'")
+	}
+
+	if p.HealthCheckInterval <= 0 {
+		return fmt.Errorf("HealthCheckInterval field must be a positive number of seconds")
+	}
+
+	if p.FailureThreshold <= 0 {
+		return fmt.Errorf("FailureThreshold field must be a positive number")
+	}
+
+	pattern := `^[a-zA-Z0-9]*(\.[a-zA-Z0-9]*)*$`
+	for _, m := range p.Members {
+		matched, err := regexp.MatchString(pattern, m.Name)
+		if err != nil || !matched {
+			return fmt.Errorf("Member name invalid: " + m.Name)
+		}
+	}
+
+	return nil
+}
+
+func loadUplinkPolicy() (UplinkPolicy, error) {
+	UplinkPolicyMtx.Lock()
+	defer UplinkPolicyMtx.Unlock()
+
+	return loadUplinkPolicyLocked()
+}
+
+func loadUplinkPolicyLocked() (UplinkPolicy, error) {
+	policy := defaultUplinkPolicy()
+
+	data, err := ioutil.ReadFile(UplinkPolicyPath)
+	if err != nil {
+		return policy, err
+	}
+
+	err = json.Unmarshal(data, &policy)
+	if err != nil {
+		log.Println(err)
+		return policy, err
+	}
+
+	return policy, nil
+}
+
+func saveUplinkPolicyLocked(policy UplinkPolicy) error {
+	file, _ := json.MarshalIndent(policy, "", " ")
+	err := ioutil.WriteFile(UplinkPolicyPath, file, 0600)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+/* Health checking */
+
+type UplinkHealthStatus struct {
+	Name                string
+	Up                  bool
+	RTTMs               int
+	LossPercent         int
+	ConsecutiveFailures int
+	CurrentMetric       int
+}
+
+var UplinkHealthMtx sync.Mutex
+var UplinkHealthState = map[string]*UplinkHealthStatus{}
+
+func probeUplink(iface string, target string) (up bool, rttMs int, lossPercent int) {
+	//bind to iface so each uplink is probed independently instead of
+	//whatever the kernel's current default route happens to send traffic
+	//through
+	out, err := exec.Command("ping", "-I", iface, "-c", "3", "-W", "1", target).Output()
+	if err != nil {
+		return false, 0, 100
+	}
+
+	lossRe := regexp.MustCompile(`(\d+)% packet loss`)
+	if m := lossRe.FindStringSubmatch(string(out)); m != nil {
+		lossPercent, _ = strconv.Atoi(m[1])
+	}
+
+	rttRe := regexp.MustCompile(`= [\d.]+/([\d.]+)/`)
+	if m := rttRe.FindStringSubmatch(string(out)); m != nil {
+		rtt, _ := strconv.ParseFloat(m[1], 64)
+		rttMs = int(rtt)
+	}
+
+	return lossPercent < 100, rttMs, lossPercent
+}
+
+func applyUplinkRoutes(policy UplinkPolicy) {
+	healthy := []UplinkMember{}
+	meteredHealthy := []UplinkMember{}
+	for _, m := range policy.Members {
+		UplinkHealthMtx.Lock()
+		status, ok := UplinkHealthState[m.Name]
+		UplinkHealthMtx.Unlock()
+		if !ok || !status.Up {
+			continue
+		}
+		if m.Metered {
+			meteredHealthy = append(meteredHealthy, m)
+		} else {
+			healthy = append(healthy, m)
+		}
+	}
+
+	//only fall back to metered uplinks (e.g. a cellular modem) once every
+	//unmetered uplink is down
+	if len(healthy) == 0 {
+		healthy = meteredHealthy
+	}
+
+	//clear any existing SPR-managed default route before reapplying
+	exec.Command("ip", "route", "del", "default").Run()
+
+	if len(healthy) == 0 {
+		log.Println("No healthy uplinks, leaving default route unset")
+		return
+	}
+
+	if policy.Mode == UplinkModeFailover {
+		best := healthy[0]
+		for _, m := range healthy {
+			if m.Metric < best.Metric {
+				best = m
+			}
+		}
+		args := []string{"route", "add", "default", "dev", best.Name, "metric", strconv.Itoa(best.Metric)}
+		err := exec.Command("ip", args...).Run()
+		if err != nil {
+			log.Println("Error setting failover default route:", err)
+		}
+		return
+	}
+
+	//load-balance: weighted ECMP nexthops
+	args := []string{"route", "add", "default"}
+	for _, m := range healthy {
+		weight := m.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		args = append(args, "nexthop", "dev", m.Name, "weight", strconv.Itoa(weight))
+	}
+	err := exec.Command("ip", args...).Run()
+	if err != nil {
+		log.Println("Error setting load-balanced default route:", err)
+	}
+}
+
+func runUplinkHealthCheckOnce(policy UplinkPolicy) {
+	for _, m := range policy.Members {
+		up, rtt, loss := probeUplink(m.Name, policy.HealthCheckTarget)
+
+		UplinkHealthMtx.Lock()
+		status, ok := UplinkHealthState[m.Name]
+		if !ok {
+			//seed as down so a healthy first probe is a real up transition,
+			//not a no-op against an assumed-healthy default
+			status = &UplinkHealthStatus{Name: m.Name, Up: false}
+			UplinkHealthState[m.Name] = status
+		}
+
+		if up {
+			status.ConsecutiveFailures = 0
+		} else {
+			status.ConsecutiveFailures++
+		}
+
+		wasUp := status.Up
+		status.Up = status.ConsecutiveFailures < policy.FailureThreshold
+		status.RTTMs = rtt
+		status.LossPercent = loss
+		status.CurrentMetric = m.Metric
+		changed := wasUp != status.Up
+		UplinkHealthMtx.Unlock()
+
+		if changed {
+			log.Println("Uplink", m.Name, "health changed, up =", status.Up)
+		}
+	}
+
+	//always reconcile routes against the freshly probed state, not just on
+	//a changed up/down transition, so a policy save with brand-new members
+	//(no prior UplinkHealthState entry) still gets a route installed
+	applyUplinkRoutes(policy)
+}
+
+// StartUplinkHealthMonitor runs the health-check loop for as long as the
+// process is alive, reloading the policy each interval so updates via
+// updateUplinkPolicy take effect without a restart.
+func StartUplinkHealthMonitor() {
+	for {
+		policy, err := loadUplinkPolicy()
+		if err != nil {
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		if len(policy.Members) > 0 {
+			runUplinkHealthCheckOnce(policy)
+		}
+
+		time.Sleep(time.Duration(policy.HealthCheckInterval) * time.Second)
+	}
+}
+
+func init() {
+	go StartUplinkHealthMonitor()
+}
+
+/* HTTP handlers */
+
+func getUplinkPolicy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	policy, err := loadUplinkPolicy()
+	if err != nil {
+		http.Error(w, "Failed to load uplink policy", 400)
+		return
+	}
+	json.NewEncoder(w).Encode(policy)
+}
+
+func updateUplinkPolicy(w http.ResponseWriter, r *http.Request) {
+	policy := UplinkPolicy{}
+	err := json.NewDecoder(r.Body).Decode(&policy)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	err = policy.Validate()
+	if err != nil {
+		log.Println("Validation error:", err)
+		http.Error(w, "Failed to validate policy "+err.Error(), 400)
+		return
+	}
+
+	UplinkPolicyMtx.Lock()
+	err = saveUplinkPolicyLocked(policy)
+	UplinkPolicyMtx.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	//probe members and apply routes immediately so a newly saved policy
+	//(or a brand-new member with no prior health state) gets a working
+	//default route right away, instead of waiting on stale/empty state
+	runUplinkHealthCheckOnce(policy)
+}
+
+func getUplinkHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	UplinkHealthMtx.Lock()
+	statuses := []UplinkHealthStatus{}
+	for _, status := range UplinkHealthState {
+		statuses = append(statuses, *status)
+	}
+	UplinkHealthMtx.Unlock()
+
+	json.NewEncoder(w).Encode(statuses)
+}
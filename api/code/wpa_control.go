@@ -0,0 +1,335 @@
+/*
+wpa_supplicant control interface client
+
+Talks to the Unix control socket that wpa_supplicant opens under the
+ctrl_interface directory we configure via writeWPAs (DIR=/var/run/wpa_supplicant_<iface>).
+It provides request/reply commands and an attached event stream, used here
+to query status and signal and to stream events without a daemon restart.
+Network configuration changes still go through writeWPAs and a WIFI-UPLINK
+plugin restart; nothing in this file adds or edits networks live.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var wpaCtrlDir = TEST_PREFIX + "/var/run/wpa_supplicant_"
+
+var WPAControlsMtx sync.Mutex
+var WPAControls = map[string]*WPAControl{}
+
+// WPAControl holds two separate sockets to the same ctrl_interface: cmdConn
+// is a plain, un-ATTACHed connection used only for synchronous Request/reply
+// commands, and eventConn is a dedicated ATTACHed connection read only by
+// readEvents. wpa_supplicant only broadcasts unsolicited CTRL-EVENT-*
+// messages to ATTACHed connections, so keeping cmdConn unattached means
+// Request never has to race readEvents for the same socket's replies.
+type WPAControl struct {
+	Iface          string
+	cmdConn        *net.UnixConn
+	cmdLocalPath   string
+	eventConn      *net.UnixConn
+	eventLocalPath string
+	reqMtx         sync.Mutex
+	events         chan string
+	closed         chan struct{}
+	closeOnce      sync.Once
+
+	deadMtx sync.Mutex
+	dead    bool
+}
+
+func dialWpaCtrl(iface string, tag string) (*net.UnixConn, string, error) {
+	ctrlPath := wpaCtrlDir + iface + "/" + iface
+	localPath := fmt.Sprintf("/tmp/wpa_ctrl_%s_%s_%d.sock", iface, tag, os.Getpid())
+	os.Remove(localPath)
+
+	laddr := &net.UnixAddr{Name: localPath, Net: "unixgram"}
+	raddr := &net.UnixAddr{Name: ctrlPath, Net: "unixgram"}
+
+	conn, err := net.DialUnix("unixgram", laddr, raddr)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect to wpa_supplicant control socket: %w", err)
+	}
+
+	return conn, localPath, nil
+}
+
+func NewWPAControl(iface string) (*WPAControl, error) {
+	cmdConn, cmdLocalPath, err := dialWpaCtrl(iface, "cmd")
+	if err != nil {
+		return nil, err
+	}
+
+	eventConn, eventLocalPath, err := dialWpaCtrl(iface, "event")
+	if err != nil {
+		cmdConn.Close()
+		os.Remove(cmdLocalPath)
+		return nil, err
+	}
+
+	return &WPAControl{
+		Iface:          iface,
+		cmdConn:        cmdConn,
+		cmdLocalPath:   cmdLocalPath,
+		eventConn:      eventConn,
+		eventLocalPath: eventLocalPath,
+		events:         make(chan string, 32),
+		closed:         make(chan struct{}),
+	}, nil
+}
+
+func (c *WPAControl) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.cmdConn.Close()
+		os.Remove(c.cmdLocalPath)
+		c.eventConn.Close()
+		os.Remove(c.eventLocalPath)
+	})
+}
+
+// markDead flags this connection as unusable, e.g. after wpa_supplicant was
+// restarted and deleted the ctrl_interface socket out from under it.
+// getWPAControl checks this to tear down and recreate the cached entry
+// instead of handing back a connection that will never succeed again.
+func (c *WPAControl) markDead() {
+	c.deadMtx.Lock()
+	c.dead = true
+	c.deadMtx.Unlock()
+}
+
+func (c *WPAControl) isDead() bool {
+	c.deadMtx.Lock()
+	defer c.deadMtx.Unlock()
+	return c.dead
+}
+
+// Request sends a command on the dedicated command connection and waits
+// for its synchronous reply. Not safe to call concurrently with itself,
+// serialized via reqMtx.
+func (c *WPAControl) Request(cmd string) (string, error) {
+	c.reqMtx.Lock()
+	defer c.reqMtx.Unlock()
+
+	_, err := c.cmdConn.Write([]byte(cmd))
+	if err != nil {
+		c.markDead()
+		return "", err
+	}
+
+	c.cmdConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4096)
+	n, err := c.cmdConn.Read(buf)
+	if err != nil {
+		c.markDead()
+		return "", err
+	}
+
+	return string(buf[:n]), nil
+}
+
+// Attach sends ATTACH on the dedicated event connection and starts a
+// goroutine that forwards unsolicited messages on the Events channel
+// until Close is called.
+func (c *WPAControl) Attach() error {
+	c.eventConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := c.eventConn.Write([]byte("ATTACH"))
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4096)
+	n, err := c.eventConn.Read(buf)
+	if err != nil {
+		return err
+	}
+
+	reply := string(buf[:n])
+	if !strings.HasPrefix(strings.TrimSpace(reply), "OK") {
+		return fmt.Errorf("ATTACH failed: %s", reply)
+	}
+
+	go c.readEvents()
+	return nil
+}
+
+func (c *WPAControl) readEvents() {
+	buf := make([]byte, 4096)
+	for {
+		select {
+		case <-c.closed:
+			return
+		default:
+		}
+
+		c.eventConn.SetReadDeadline(time.Now().Add(1 * time.Second))
+		n, err := c.eventConn.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			c.markDead()
+			return
+		}
+
+		msg := string(buf[:n])
+		if len(msg) > 0 && msg[0] == '<' {
+			select {
+			case c.events <- msg:
+			default:
+				//drop if nobody is listening fast enough
+			}
+		}
+	}
+}
+
+func (c *WPAControl) Events() <-chan string {
+	return c.events
+}
+
+// getWPAControl returns a cached, attached control connection for iface,
+// opening one lazily on first use. A cached connection that's gone dead
+// (e.g. wpa_supplicant was restarted and its ctrl_interface socket was
+// recreated out from under us) is torn down and replaced.
+func getWPAControl(iface string) (*WPAControl, error) {
+	WPAControlsMtx.Lock()
+	defer WPAControlsMtx.Unlock()
+
+	if c, ok := WPAControls[iface]; ok {
+		if !c.isDead() {
+			return c, nil
+		}
+		c.Close()
+		delete(WPAControls, iface)
+	}
+
+	c, err := NewWPAControl(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Attach(); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	WPAControls[iface] = c
+	return c, nil
+}
+
+type WPAStatus struct {
+	Iface     string
+	State     string
+	SSID      string
+	BSSID     string
+	Frequency int
+	Signal    int
+}
+
+func parseWpaKV(reply string) map[string]string {
+	kv := map[string]string{}
+	for _, line := range strings.Split(reply, "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		kv[parts[0]] = parts[1]
+	}
+	return kv
+}
+
+func wifiUplinkStatus(w http.ResponseWriter, r *http.Request) {
+	iface := r.URL.Query().Get("iface")
+	if iface == "" {
+		http.Error(w, "iface parameter is required", 400)
+		return
+	}
+
+	ctrl, err := getWPAControl(iface)
+	if err != nil {
+		log.Println("Error connecting to wpa control socket:", err)
+		http.Error(w, "Failed to reach wpa_supplicant for "+iface, 400)
+		return
+	}
+
+	reply, err := ctrl.Request("STATUS")
+	if err != nil {
+		log.Println("Error requesting STATUS:", err)
+		http.Error(w, "Failed to query status", 400)
+		return
+	}
+
+	kv := parseWpaKV(reply)
+	freq, _ := strconv.Atoi(kv["freq"])
+
+	status := WPAStatus{
+		Iface:     iface,
+		State:     kv["wpa_state"],
+		SSID:      kv["ssid"],
+		BSSID:     kv["bssid"],
+		Frequency: freq,
+	}
+
+	signalReply, err := ctrl.Request("SIGNAL_POLL")
+	if err == nil {
+		sigKV := parseWpaKV(signalReply)
+		signal, err := strconv.Atoi(sigKV["RSSI"])
+		if err == nil {
+			status.Signal = signal
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// wifiUplinkEvents streams CTRL-EVENT-* messages for iface to the client
+// via Server-Sent Events, e.g. CONNECTED, DISCONNECTED, SSID-TEMP-DISABLED,
+// SCAN-RESULTS.
+func wifiUplinkEvents(w http.ResponseWriter, r *http.Request) {
+	iface := r.URL.Query().Get("iface")
+	if iface == "" {
+		http.Error(w, "iface parameter is required", 400)
+		return
+	}
+
+	ctrl, err := getWPAControl(iface)
+	if err != nil {
+		log.Println("Error connecting to wpa control socket:", err)
+		http.Error(w, "Failed to reach wpa_supplicant for "+iface, 400)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg := <-ctrl.Events():
+			fmt.Fprintf(w, "data: %s\n\n", strings.TrimSpace(msg))
+			flusher.Flush()
+		case <-time.After(30 * time.Second):
+			fmt.Fprintf(w, ": keepalive\n\n")
+			flusher.Flush()
+		}
+	}
+}
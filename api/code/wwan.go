@@ -0,0 +1,486 @@
+/*
+Cellular/LTE modem uplink support (wwan), backed by ModemManager.
+
+This adds a third Uplink subtype alongside wifi and ppp. Modem settings
+are persisted here and applied by shelling out to `mmcli`, the same way
+the wifi and ppp subsystems shell out to wpa_cli/pppd rather than
+talking to dbus directly.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var WWANConfigPath = TEST_PREFIX + "/configs/wwan/modems.json"
+
+var WWANmtx sync.Mutex
+
+type ModemConfig struct {
+	Modem          string //modem identifier as reported by `mmcli -L`, e.g. "0"
+	Iface          string //net interface, e.g. "wwan0", used as the InterfaceConfig/UplinkMember name
+	Enabled        bool
+	APN            string
+	Username       string `json:",omitempty"`
+	Password       string `json:",omitempty"`
+	PIN            string `json:",omitempty"`
+	AllowedModes   string `json:",omitempty"`
+	PreferredMode  string `json:",omitempty"`
+	RoamingAllowed bool   `json:",omitempty"`
+	IPType         string `json:",omitempty"`
+
+	//Metered marks this modem as a backup that should only be used once
+	//the other configured uplinks are unhealthy
+	Metered bool `json:",omitempty"`
+}
+
+type WWANConfig struct {
+	Modems []ModemConfig
+}
+
+func (m *ModemConfig) Validate() error {
+	if m.Modem == "" {
+		return fmt.Errorf("Modem field empty")
+	}
+
+	if m.Iface == "" {
+		return fmt.Errorf("Iface field empty")
+	}
+
+	matched, err := regexp.MatchString(`^[a-zA-Z0-9]*(\.[a-zA-Z0-9]*)*$`, m.Iface)
+	if err != nil || !matched {
+		return fmt.Errorf("Iface field is invalid")
+	}
+
+	if m.APN == "" {
+		return fmt.Errorf("APN field empty")
+	}
+
+	for _, field := range []struct {
+		name  string
+		value string
+	}{{"APN", m.APN}, {"Username", m.Username}, {"Password", m.Password}, {"PIN", m.PIN}} {
+		if strings.Contains(field.value, "\n") {
+			return fmt.Errorf(field.name + " field contains newline characters")
+		}
+	}
+
+	if m.PIN != "" {
+		_, err := strconv.Atoi(m.PIN)
+		if err != nil {
+			return fmt.Errorf("PIN field must contain numeric value")
+		}
+	}
+
+	switch m.IPType {
+	case "", "ipv4", "ipv6", "ipv4v6":
+	default:
+		return fmt.Errorf("IPType field must be 'ipv4', 'ipv6', or 'ipv4v6'")
+	}
+
+	return nil
+}
+
+func loadWWANConfig() (WWANConfig, error) {
+	WWANmtx.Lock()
+	defer WWANmtx.Unlock()
+
+	return loadWWANConfigLocked()
+}
+
+func loadWWANConfigLocked() (WWANConfig, error) {
+	config := WWANConfig{}
+
+	data, err := ioutil.ReadFile(WWANConfigPath)
+	if err != nil {
+		log.Println(err)
+		return config, err
+	}
+
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		log.Println(err)
+		return config, err
+	}
+
+	return config, nil
+}
+
+func applyModemConfig(modem ModemConfig) error {
+	if modem.PIN != "" {
+		err := exec.Command("mmcli", "-m", modem.Modem, "--pin="+modem.PIN).Run()
+		if err != nil {
+			return fmt.Errorf("failed to unlock SIM: %w", err)
+		}
+	}
+
+	if modem.AllowedModes != "" {
+		args := []string{"-m", modem.Modem, "--set-allowed-modes=" + modem.AllowedModes}
+		if modem.PreferredMode != "" {
+			args = append(args, "--set-preferred-mode="+modem.PreferredMode)
+		}
+		err := exec.Command("mmcli", args...).Run()
+		if err != nil {
+			return fmt.Errorf("failed to set allowed modes: %w", err)
+		}
+	}
+
+	connectArgs := []string{"apn=" + modem.APN}
+	if modem.Username != "" {
+		connectArgs = append(connectArgs, "user="+modem.Username)
+	}
+	if modem.Password != "" {
+		connectArgs = append(connectArgs, "password="+modem.Password)
+	}
+	if modem.IPType != "" {
+		connectArgs = append(connectArgs, "ip-type="+modem.IPType)
+	}
+
+	if !modem.Enabled {
+		exec.Command("mmcli", "-m", modem.Modem, "--simple-disconnect").Run()
+		return nil
+	}
+
+	err := exec.Command("mmcli", "-m", modem.Modem, "--simple-connect="+strings.Join(connectArgs, ",")).Run()
+	if err != nil {
+		return fmt.Errorf("failed to connect bearer: %w", err)
+	}
+
+	return nil
+}
+
+func isWWANUplinkIfaceEnabled(Name string, interfaces []InterfaceConfig) bool {
+	for _, iface := range interfaces {
+		if iface.Name == Name {
+			if iface.Type == "Uplink" && iface.Subtype == "wwan" {
+				return iface.Enabled
+			}
+			break
+		}
+	}
+	return false
+}
+
+func insertModemConfigAndSave(interfaces []InterfaceConfig, new_modem ModemConfig) error {
+	WWANmtx.Lock()
+	defer WWANmtx.Unlock()
+
+	config := WWANConfig{}
+	loaded, err := loadWWANConfigLocked()
+	if err == nil {
+		config = loaded
+	}
+
+	modems := []ModemConfig{}
+	found := false
+	for _, modem := range config.Modems {
+		if modem.Modem == new_modem.Modem {
+			modems = append(modems, new_modem)
+			found = true
+		} else {
+			//update the enabled status
+			modem.Enabled = isWWANUplinkIfaceEnabled(modem.Iface, interfaces)
+			modems = append(modems, modem)
+		}
+	}
+	if !found {
+		modems = append(modems, new_modem)
+	}
+
+	config.Modems = modems
+
+	file, _ := json.MarshalIndent(config, "", " ")
+	err = ioutil.WriteFile(WWANConfigPath, file, 0600)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	err = syncModemUplinkMember(new_modem)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return applyModemConfig(new_modem)
+}
+
+// syncModemUplinkMember keeps UplinkPolicy.Members in sync with this
+// modem's enabled/Metered state, so a cellular modem marked Metered only
+// gets the default route once the other uplinks are unhealthy. Its bearer
+// is still connected by applyModemConfig whenever the modem is enabled.
+func syncModemUplinkMember(modem ModemConfig) error {
+	UplinkPolicyMtx.Lock()
+	defer UplinkPolicyMtx.Unlock()
+
+	policy, err := loadUplinkPolicyLocked()
+	if err != nil {
+		policy = defaultUplinkPolicy()
+	}
+
+	members := []UplinkMember{}
+	found := false
+	for _, member := range policy.Members {
+		if member.Name != modem.Iface {
+			members = append(members, member)
+			continue
+		}
+		found = true
+		if modem.Enabled {
+			member.Metered = modem.Metered
+			members = append(members, member)
+		}
+		//disabled: drop the member from the policy
+	}
+
+	if !found && modem.Enabled {
+		members = append(members, UplinkMember{Name: modem.Iface, Metered: modem.Metered})
+	}
+
+	policy.Members = members
+	return saveUplinkPolicyLocked(policy)
+}
+
+/* HTTP handlers */
+
+type DetectedModem struct {
+	Modem string
+	Model string
+}
+
+func listDetectedModems(w http.ResponseWriter, r *http.Request) {
+	out, err := exec.Command("mmcli", "-L", "-J").Output()
+	if err != nil {
+		log.Println("Error listing modems:", err)
+		http.Error(w, "Failed to list modems", 400)
+		return
+	}
+
+	var raw struct {
+		Modems []string `json:"modem-list"`
+	}
+	err = json.Unmarshal(out, &raw)
+	if err != nil {
+		http.Error(w, "Failed to parse mmcli output", 400)
+		return
+	}
+
+	modemRe := regexp.MustCompile(`/Modem/(\d+)$`)
+	modems := []DetectedModem{}
+	for _, path := range raw.Modems {
+		m := modemRe.FindStringSubmatch(path)
+		if m == nil {
+			continue
+		}
+		modems = append(modems, DetectedModem{Modem: m[1]})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(modems)
+}
+
+func getWWANConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	config, err := loadWWANConfig()
+	if err != nil {
+		http.Error(w, "Failed to load wwan configuration", 400)
+		return
+	}
+	json.NewEncoder(w).Encode(config)
+}
+
+func updateWWANConfig(w http.ResponseWriter, r *http.Request) {
+	modem := ModemConfig{}
+	err := json.NewDecoder(r.Body).Decode(&modem)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	err = modem.Validate()
+	if err != nil {
+		log.Println("Validation error:", err)
+		http.Error(w, "Failed to validate modem "+err.Error(), 400)
+		return
+	}
+
+	//update the interface type
+	interfaces, err := updateInterfaceType(modem.Iface, "Uplink", "wwan", modem.Enabled)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	err = insertModemConfigAndSave(interfaces, modem)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+}
+
+type WWANUnlockRequest struct {
+	Modem string
+	PIN   string
+}
+
+func unlockWWANModem(w http.ResponseWriter, r *http.Request) {
+	req := WWANUnlockRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if req.Modem == "" || req.PIN == "" {
+		http.Error(w, "Modem and PIN fields are required", 400)
+		return
+	}
+
+	err = exec.Command("mmcli", "-m", req.Modem, "--pin="+req.PIN).Run()
+	if err != nil {
+		log.Println("Error unlocking modem:", err)
+		http.Error(w, "Failed to unlock SIM", 400)
+		return
+	}
+}
+
+type WWANOperator struct {
+	OperatorID   string
+	OperatorName string
+	AccessTech   string
+}
+
+func scanWWANOperators(w http.ResponseWriter, r *http.Request) {
+	modem := r.URL.Query().Get("modem")
+	if modem == "" {
+		http.Error(w, "modem parameter is required", 400)
+		return
+	}
+
+	out, err := exec.Command("mmcli", "-m", modem, "--3gpp-scan").Output()
+	if err != nil {
+		log.Println("Error scanning operators:", err)
+		http.Error(w, "Failed to scan operators", 400)
+		return
+	}
+
+	//each result line looks like: <id>, <status>, '<name>', '<tech>'
+	results := []WWANOperator{}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) < 4 {
+			continue
+		}
+		results = append(results, WWANOperator{
+			OperatorID:   strings.TrimSpace(fields[0]),
+			OperatorName: strings.Trim(strings.TrimSpace(fields[2]), "'"),
+			AccessTech:   strings.Trim(strings.TrimSpace(fields[3]), "'"),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+type WWANSignalStatus struct {
+	Modem             string
+	RegistrationState string
+	AccessTech        string
+	SignalQuality     int
+}
+
+func getWWANSignal(w http.ResponseWriter, r *http.Request) {
+	modem := r.URL.Query().Get("modem")
+	if modem == "" {
+		http.Error(w, "modem parameter is required", 400)
+		return
+	}
+
+	out, err := exec.Command("mmcli", "-m", modem, "-J").Output()
+	if err != nil {
+		log.Println("Error querying modem status:", err)
+		http.Error(w, "Failed to query modem status", 400)
+		return
+	}
+
+	var raw struct {
+		Modem struct {
+			Generic struct {
+				SignalQuality struct {
+					Value string `json:"value"`
+				} `json:"signal-quality"`
+				State      string   `json:"state"`
+				AccessTech []string `json:"access-technologies"`
+			} `json:"generic"`
+		} `json:"modem"`
+	}
+	err = json.Unmarshal(out, &raw)
+	if err != nil {
+		http.Error(w, "Failed to parse mmcli output", 400)
+		return
+	}
+
+	quality, _ := strconv.Atoi(raw.Modem.Generic.SignalQuality.Value)
+	accessTech := ""
+	if len(raw.Modem.Generic.AccessTech) > 0 {
+		accessTech = raw.Modem.Generic.AccessTech[0]
+	}
+
+	status := WWANSignalStatus{
+		Modem:             modem,
+		RegistrationState: raw.Modem.Generic.State,
+		AccessTech:        accessTech,
+		SignalQuality:     quality,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+type WWANUsage struct {
+	Iface   string
+	RXBytes uint64
+	TXBytes uint64
+}
+
+func getWWANUsage(w http.ResponseWriter, r *http.Request) {
+	iface := r.URL.Query().Get("iface")
+
+	pattern := `^wwan[0-9]+$`
+	matched, err := regexp.MatchString(pattern, iface)
+	if iface == "" || err != nil || !matched {
+		http.Error(w, "Invalid iface name", 400)
+		return
+	}
+
+	statsDir := TEST_PREFIX + "/sys/class/net/" + iface + "/statistics/"
+
+	rxData, err := ioutil.ReadFile(statsDir + "rx_bytes")
+	if err != nil {
+		http.Error(w, "Failed to read usage for "+iface, 400)
+		return
+	}
+	txData, err := ioutil.ReadFile(statsDir + "tx_bytes")
+	if err != nil {
+		http.Error(w, "Failed to read usage for "+iface, 400)
+		return
+	}
+
+	rx, _ := strconv.ParseUint(strings.TrimSpace(string(rxData)), 10, 64)
+	tx, _ := strconv.ParseUint(strings.TrimSpace(string(txData)), 10, 64)
+
+	usage := WWANUsage{Iface: iface, RXBytes: rx, TXBytes: tx}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}